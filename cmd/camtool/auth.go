@@ -0,0 +1,59 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"camlistore.org/pkg/cmdmain"
+)
+
+type authCmd struct{}
+
+func init() {
+	cmdmain.RegisterCommand("auth", func(flags *flag.FlagSet) cmdmain.CommandRunner {
+		return new(authCmd)
+	})
+}
+
+func (c *authCmd) Describe() string {
+	return "Store credentials in a secret backend for use by the client config."
+}
+
+func (c *authCmd) Usage() {
+	fmt.Fprintf(cmdmain.Stderr, "Usage: camtool auth store -backend=keychain|vault -ref=<ref>\n"+
+		"  Prompts for the secret on stdin and writes it to the named backend,\n"+
+		"  printing the config \"auth\" value (e.g. \"keychain:camlistore/default\")\n"+
+		"  to use for it afterwards.\n")
+}
+
+func (c *authCmd) RunCommand(args []string) error {
+	if len(args) == 0 || args[0] != "store" {
+		c.Usage()
+		return cmdmain.ErrUsage
+	}
+	var backend, ref string
+	fs := flag.NewFlagSet("auth store", flag.ExitOnError)
+	fs.StringVar(&backend, "backend", "keychain", "secret backend to store into: keychain or vault")
+	fs.StringVar(&ref, "ref", "", "backend-specific reference, e.g. \"camlistore/default\" or \"secret/data/camli#password\"")
+	fs.Parse(args[1:])
+	if ref == "" {
+		return cmdmain.ErrUsage
+	}
+	return storeSecret(backend, ref)
+}
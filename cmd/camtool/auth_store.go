@@ -0,0 +1,119 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"camlistore.org/pkg/cmdmain"
+)
+
+// storeSecret reads a secret from stdin and writes it into the named
+// backend under ref, then prints the config "auth" value that
+// dereferences it.
+func storeSecret(backend, ref string) error {
+	fmt.Fprint(cmdmain.Stderr, "Secret: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("reading secret from stdin: %v", err)
+	}
+	secretValue := strings.TrimRight(line, "\r\n")
+
+	switch backend {
+	case "keychain":
+		if err := storeKeychain(ref, secretValue); err != nil {
+			return err
+		}
+	case "vault":
+		if err := storeVault(ref, secretValue); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown backend %q; want \"keychain\" or \"vault\"", backend)
+	}
+	fmt.Fprintf(cmdmain.Stdout, "%s:%s\n", backend, ref)
+	return nil
+}
+
+func storeKeychain(ref, secretValue string) error {
+	i := strings.LastIndex(ref, "/")
+	service, account := "camlistore", ref
+	if i >= 0 {
+		service, account = ref[:i], ref[i+1:]
+	}
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("/usr/bin/security", "add-generic-password",
+			"-U", "-s", service, "-a", account, "-w", secretValue)
+	case "linux":
+		cmd = exec.Command("secret-tool", "store", "--label", service+"/"+account,
+			"service", service, "account", account)
+		cmd.Stdin = strings.NewReader(secretValue + "\n")
+	default:
+		return fmt.Errorf("keychain storage is not supported on %s", runtime.GOOS)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("storing to keychain: %v: %s", err, stderr.String())
+	}
+	return nil
+}
+
+func storeVault(ref, secretValue string) error {
+	path, field, ok := cutLast(ref, "#")
+	if !ok {
+		return fmt.Errorf("vault ref %q must be of the form path#field", ref)
+	}
+	addr := os.Getenv("VAULT_ADDR")
+	token := os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return fmt.Errorf("VAULT_ADDR and VAULT_TOKEN must be set to store a vault secret")
+	}
+	body := fmt.Sprintf(`{"data":{%q:%q}}`, field, secretValue)
+	req, err := http.NewRequest("POST", addr+"/v1/"+path, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("vault POST %s: %s", path, resp.Status)
+	}
+	return nil
+}
+
+// cutLast splits s on the last occurrence of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"camlistore.org/pkg/client"
+	"camlistore.org/pkg/cmdmain"
+)
+
+type certCmd struct{}
+
+func init() {
+	cmdmain.RegisterCommand("cert", func(flags *flag.FlagSet) cmdmain.CommandRunner {
+		return new(certCmd)
+	})
+}
+
+func (c *certCmd) Describe() string {
+	return "Inspect and manage TOFU-pinned server certificates."
+}
+
+func (c *certCmd) Usage() {
+	fmt.Fprintf(cmdmain.Stderr, "Usage: camtool cert show [host:port]\n"+
+		"       camtool cert trust <host:port> [fingerprint] [-pending]\n"+
+		"       camtool cert rotate <host:port>\n"+
+		"       camtool cert forget <host:port>\n"+
+		"\n"+
+		"\"trust\" without -pending pins fingerprint as the host's certificate\n"+
+		"outright. With -pending, it stages fingerprint as a pending rotation\n"+
+		"alongside the existing pin, without replacing it: the client will\n"+
+		"accept either certificate for the next 30 days, and \"cert rotate\"\n"+
+		"promotes the pending one to be the pin.\n")
+}
+
+func (c *certCmd) RunCommand(args []string) error {
+	if len(args) == 0 {
+		c.Usage()
+		return cmdmain.ErrUsage
+	}
+	switch args[0] {
+	case "show":
+		host := ""
+		if len(args) > 1 {
+			host = args[1]
+		}
+		return c.show(host)
+	case "trust":
+		if len(args) < 2 {
+			return cmdmain.ErrUsage
+		}
+		host := args[1]
+		// "-pending" and the fingerprint can come in either order
+		// ("<fp> -pending" or "-pending <fp>"), so rather than lean on
+		// flag.Parse (which stops at the first non-flag argument and
+		// would silently drop whichever one comes second), just split
+		// args[2:] into the bool flag and the one remaining positional
+		// fingerprint ourselves.
+		var pending bool
+		var fingerprint string
+		for _, a := range args[2:] {
+			if a == "-pending" || a == "--pending" {
+				pending = true
+				continue
+			}
+			if fingerprint != "" {
+				return cmdmain.ErrUsage
+			}
+			fingerprint = a
+		}
+		return c.trust(host, fingerprint, pending)
+	case "rotate":
+		if len(args) != 2 {
+			return cmdmain.ErrUsage
+		}
+		return c.rotate(args[1])
+	case "forget":
+		if len(args) != 2 {
+			return cmdmain.ErrUsage
+		}
+		return c.forget(args[1])
+	default:
+		c.Usage()
+		return cmdmain.ErrUsage
+	}
+}
+
+func knownHostsOf(conf map[string]interface{}) map[string]interface{} {
+	hosts, ok := conf["knownHosts"].(map[string]interface{})
+	if !ok {
+		hosts = make(map[string]interface{})
+		conf["knownHosts"] = hosts
+	}
+	return hosts
+}
+
+func (c *certCmd) show(host string) error {
+	conf, err := loadRawConfig()
+	if err != nil {
+		return err
+	}
+	hosts := knownHostsOf(conf)
+	if host != "" {
+		entry, ok := hosts[host].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("no pinned certificate for %q", host)
+		}
+		printHostCert(host, entry)
+		return nil
+	}
+	names := make([]string, 0, len(hosts))
+	for name := range hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entry, _ := hosts[name].(map[string]interface{})
+		printHostCert(name, entry)
+	}
+	return nil
+}
+
+func printHostCert(host string, entry map[string]interface{}) {
+	fp, _ := entry["fingerprint"].(string)
+	fmt.Fprintf(cmdmain.Stdout, "%s\t%s\n", host, fp)
+	if pending, ok := entry["pending"].(string); ok && pending != "" {
+		since, _ := entry["pendingSince"].(string)
+		fmt.Fprintf(cmdmain.Stdout, "\tpending: %s (since %s)\n", pending, since)
+	}
+}
+
+// trust pins fingerprint for host outright, clearing any pending
+// rotation, unless pending is true, in which case it instead stages
+// fingerprint as a pending rotation alongside the host's existing pin
+// (see CheckServerCert: a pending fingerprint is only ever accepted
+// because it was staged here, never auto-learned from a mismatch). If
+// fingerprint is omitted (and pending is false), this also serves as
+// the migration path off a legacy flat "trustedCerts" list: a
+// single-entry list is adopted as the pin for host and the list is
+// removed, which switches the client to TOFU pinning.
+func (c *certCmd) trust(host, fingerprint string, pending bool) error {
+	return client.UpdateConfigFile(func(conf map[string]interface{}) error {
+		hosts := knownHostsOf(conf)
+		if pending {
+			entry, ok := hosts[host].(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("no existing pinned certificate for %q; use \"camtool cert trust %s %s\" (without -pending) to pin it outright first", host, host, fingerprint)
+			}
+			if fingerprint == "" {
+				return fmt.Errorf("-pending requires a fingerprint")
+			}
+			entry["pending"] = fingerprint
+			entry["pendingSince"] = time.Now().Format(time.RFC3339)
+			return nil
+		}
+		if fingerprint == "" {
+			list, ok := conf["trustedCerts"].([]interface{})
+			if !ok || len(list) != 1 {
+				return fmt.Errorf("no fingerprint given, and no single legacy trustedCerts entry to migrate")
+			}
+			fp, ok := list[0].(string)
+			if !ok || fp == "" {
+				return fmt.Errorf("legacy trustedCerts entry is not a usable fingerprint")
+			}
+			fingerprint = fp
+			// Drop the legacy list: while trustedCerts is a list,
+			// the client checks it and ignores knownHosts.
+			delete(conf, "trustedCerts")
+		}
+		hosts[host] = map[string]interface{}{"fingerprint": fingerprint}
+		return nil
+	})
+}
+
+func (c *certCmd) rotate(host string) error {
+	return client.UpdateConfigFile(func(conf map[string]interface{}) error {
+		hosts := knownHostsOf(conf)
+		entry, ok := hosts[host].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("no pinned certificate for %q", host)
+		}
+		pending, _ := entry["pending"].(string)
+		if pending == "" {
+			return fmt.Errorf("%q has no pending certificate to rotate to", host)
+		}
+		hosts[host] = map[string]interface{}{"fingerprint": pending}
+		return nil
+	})
+}
+
+func (c *certCmd) forget(host string) error {
+	return client.UpdateConfigFile(func(conf map[string]interface{}) error {
+		hosts := knownHostsOf(conf)
+		delete(hosts, host)
+		return nil
+	})
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"camlistore.org/pkg/client"
+	"camlistore.org/pkg/cmdmain"
+)
+
+type profileCmd struct{}
+
+func init() {
+	cmdmain.RegisterCommand("profile", func(flags *flag.FlagSet) cmdmain.CommandRunner {
+		return new(profileCmd)
+	})
+}
+
+func (c *profileCmd) Describe() string {
+	return "List, add, or remove named server profiles in the client config."
+}
+
+func (c *profileCmd) Usage() {
+	fmt.Fprintf(cmdmain.Stderr, "Usage: camtool profile list\n"+
+		"       camtool profile use <name>\n"+
+		"       camtool profile add <name> -server=... [-auth=...] [-keyId=...] [-secretRing=...]\n"+
+		"       camtool profile remove <name>\n")
+}
+
+func (c *profileCmd) RunCommand(args []string) error {
+	if len(args) == 0 {
+		c.Usage()
+		return cmdmain.ErrUsage
+	}
+	switch args[0] {
+	case "list":
+		return c.list()
+	case "use":
+		if len(args) != 2 {
+			return cmdmain.ErrUsage
+		}
+		return c.use(args[1])
+	case "add":
+		return c.add(args[1:])
+	case "remove":
+		if len(args) != 2 {
+			return cmdmain.ErrUsage
+		}
+		return c.remove(args[1])
+	default:
+		c.Usage()
+		return cmdmain.ErrUsage
+	}
+}
+
+func loadRawConfig() (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(client.ConfigFilePath())
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", client.ConfigFilePath(), err)
+	}
+	conf := make(map[string]interface{})
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", client.ConfigFilePath(), err)
+	}
+	return conf, nil
+}
+
+func profilesOf(conf map[string]interface{}) map[string]interface{} {
+	profiles, ok := conf["profiles"].(map[string]interface{})
+	if !ok {
+		profiles = make(map[string]interface{})
+		conf["profiles"] = profiles
+	}
+	return profiles
+}
+
+func (c *profileCmd) list() error {
+	conf, err := loadRawConfig()
+	if err != nil {
+		return err
+	}
+	profiles := profilesOf(conf)
+	def, _ := conf["defaultProfile"].(string)
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		mark := "  "
+		if name == def {
+			mark = "* "
+		}
+		fmt.Fprintf(cmdmain.Stdout, "%s%s\n", mark, name)
+	}
+	return nil
+}
+
+func (c *profileCmd) use(name string) error {
+	return client.UpdateConfigFile(func(conf map[string]interface{}) error {
+		profiles := profilesOf(conf)
+		if _, ok := profiles[name]; !ok {
+			return fmt.Errorf("no such profile %q", name)
+		}
+		conf["defaultProfile"] = name
+		return nil
+	})
+}
+
+func (c *profileCmd) add(args []string) error {
+	if len(args) == 0 {
+		return cmdmain.ErrUsage
+	}
+	name := args[0]
+	var server, auth, keyId, secretRing, selfPubKeyDir string
+	fs := flag.NewFlagSet("profile add", flag.ExitOnError)
+	fs.StringVar(&server, "server", "", "server prefix for this profile")
+	fs.StringVar(&auth, "auth", "", "auth string for this profile")
+	fs.StringVar(&keyId, "keyId", "", "signing keyId for this profile")
+	fs.StringVar(&secretRing, "secretRing", "", "GPG secret ring for this profile")
+	fs.StringVar(&selfPubKeyDir, "selfPubKeyDir", "", "selfPubKeyDir for this profile")
+	fs.Parse(args[1:])
+
+	return client.UpdateConfigFile(func(conf map[string]interface{}) error {
+		profiles := profilesOf(conf)
+		profile := make(map[string]interface{})
+		if server != "" {
+			profile["server"] = server
+		}
+		if auth != "" {
+			profile["auth"] = auth
+		}
+		if keyId != "" {
+			profile["keyId"] = keyId
+		}
+		if secretRing != "" {
+			profile["secretRing"] = secretRing
+		}
+		if selfPubKeyDir != "" {
+			profile["selfPubKeyDir"] = selfPubKeyDir
+		}
+		profiles[name] = profile
+		return nil
+	})
+}
+
+func (c *profileCmd) remove(name string) error {
+	return client.UpdateConfigFile(func(conf map[string]interface{}) error {
+		profiles := profilesOf(conf)
+		delete(profiles, name)
+		if conf["defaultProfile"] == name {
+			delete(conf, "defaultProfile")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"camlistore.org/pkg/client"
+	"camlistore.org/pkg/client/configsign"
+	"camlistore.org/pkg/cmdmain"
+	"camlistore.org/pkg/jsonsign"
+
+	"gopkg.in/square/go-jose.v1"
+)
+
+type configCmd struct{}
+
+func init() {
+	cmdmain.RegisterCommand("config", func(flags *flag.FlagSet) cmdmain.CommandRunner {
+		return new(configCmd)
+	})
+}
+
+func (c *configCmd) Describe() string {
+	return "Manage the client configuration file."
+}
+
+func (c *configCmd) Usage() {
+	fmt.Fprintf(cmdmain.Stderr, "Usage: camtool config sign [-key=keyId]\n")
+}
+
+func (c *configCmd) RunCommand(args []string) error {
+	if len(args) == 0 || args[0] != "sign" {
+		c.Usage()
+		return cmdmain.ErrUsage
+	}
+	return c.runSign(args[1:])
+}
+
+// runSign re-signs the client config file in place, writing (or
+// overwriting) its detached ".sig" file. It uses the same GPG identity
+// as camput/camtool blob signing, so re-signing the config after an
+// edit requires no extra key setup.
+func (c *configCmd) runSign(args []string) error {
+	var keyId string
+	fs := flag.NewFlagSet("config sign", flag.ExitOnError)
+	fs.StringVar(&keyId, "key", "", "GPG key id to sign with; defaults to the configured keyId")
+	fs.Parse(args)
+
+	configPath := client.ConfigFilePath()
+	raw, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", configPath, err)
+	}
+
+	if keyId == "" {
+		keyId, err = client.KeyId()
+		if err != nil {
+			return err
+		}
+	}
+	entity, err := jsonsign.EntityFromSecring(keyId, client.SecretRingFile())
+	if err != nil {
+		return fmt.Errorf("loading signing key %q: %v", keyId, err)
+	}
+	if entity.PrivateKey == nil {
+		return fmt.Errorf("secret ring entry for keyId %q has no private key", keyId)
+	}
+	if entity.PrivateKey.Encrypted {
+		passphrase, err := client.KeyPassphrase()
+		if err != nil {
+			return fmt.Errorf("getting passphrase for keyId %q: %v", keyId, err)
+		}
+		if err := entity.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+			return fmt.Errorf("decrypting keyId %q with configured keyPassphrase: %v", keyId, err)
+		}
+	}
+	rsaKey, ok := entity.PrivateKey.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return fmt.Errorf("signing key %q is not an RSA key", keyId)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		return fmt.Errorf("marshaling public key for keyId %q: %v", keyId, err)
+	}
+	// Stamp the same kid configsign.ParsePublicKeys would derive for
+	// this key's DER encoding, so configsign.Verify's kid fast path
+	// matches it directly instead of falling back to trying every
+	// trusted key.
+	signingKey := jose.JsonWebKey{Key: rsaKey, KeyID: configsign.Fingerprint(der)}
+	signer, err := jose.NewSigner(jose.RS256, &signingKey)
+	if err != nil {
+		return fmt.Errorf("creating signer: %v", err)
+	}
+
+	sig, err := configsign.Sign(raw, signer)
+	if err != nil {
+		return err
+	}
+	sigPath := client.ConfigSignatureFilePath()
+	if err := ioutil.WriteFile(sigPath, []byte(sig+"\n"), 0600); err != nil {
+		return fmt.Errorf("writing %s: %v", sigPath, err)
+	}
+	fmt.Fprintf(cmdmain.Stdout, "Wrote %s\n", sigPath)
+	return nil
+}
@@ -18,15 +18,19 @@ package client
 
 import (
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"camlistore.org/pkg/auth"
 	"camlistore.org/pkg/blobref"
+	"camlistore.org/pkg/client/configsign"
+	"camlistore.org/pkg/client/secret"
 	"camlistore.org/pkg/jsonconfig"
 	"camlistore.org/pkg/jsonsign"
 	"camlistore.org/pkg/osutil"
@@ -36,11 +40,15 @@ import (
 // "server" and "password" keys.
 //
 // A main binary must call AddFlags to expose these.
-var flagServer *string
+var (
+	flagServer  *string
+	flagProfile *string // overrides the "defaultProfile" key instead
+)
 
 func AddFlags() {
 	defaultPath := ConfigFilePath()
 	flagServer = flag.String("server", "", "Camlistore server prefix. If blank, the default from the \"server\" field of "+defaultPath+" is used. Acceptable forms: https://you.example.com, example.com:1345 (https assumed), or http://you.example.com/alt-root")
+	flagProfile = flag.String("profile", "", "Name of the server profile to use from the \"profiles\" map in "+defaultPath+", overriding \"defaultProfile\". Can also be set with the CAMLI_PROFILE environment variable.")
 }
 
 // ExplicitServer returns the blobserver given in the flags, if any.
@@ -55,6 +63,44 @@ func ConfigFilePath() string {
 	return filepath.Join(osutil.CamliConfigDir(), "config")
 }
 
+// ConfigSignatureFilePath returns the path of the detached JWS
+// signature for the config file, if any.
+func ConfigSignatureFilePath() string {
+	return ConfigFilePath() + ".sig"
+}
+
+// TrustedKeysFilePath returns the path of the file holding additional
+// public keys trusted to sign the config file, for sites that don't
+// want to embed configSigningKey directly in the config.
+//
+// Prefer this over configSigningKey for real protection: a process
+// that can rewrite config can just as easily delete the
+// configSigningKey field from it, at which point an attacker-controlled
+// config with no signature is allowed through unsigned (see
+// verifyConfigSignature) unless the configSignedMarkerPath marker is
+// present, and that marker lives in the same directory as the config
+// file. A key listed only in TrustedKeysFilePath
+// isn't reachable through that path, since trusting it doesn't depend
+// on anything inside the file it's protecting.
+func TrustedKeysFilePath() string {
+	return filepath.Join(osutil.CamliConfigDir(), "trusted_keys")
+}
+
+// configSigningKey is the config field name for one or more PEM-encoded
+// public keys trusted to sign the config file. See TrustedKeysFilePath
+// for why this is advisory rather than a real defense on its own.
+const configSigningKey = "configSigningKey"
+
+// configSignedMarkerPath returns the path of a marker file written
+// after the first time a config signature is ever successfully
+// verified. Its presence means this install has opted into signed
+// configs, so if a later config has no signature (or no trusted keys)
+// at all, that's treated as tampering rather than a user who simply
+// never signed their config: see verifyConfigSignature.
+func configSignedMarkerPath() string {
+	return filepath.Join(osutil.CamliConfigDir(), "config.signed")
+}
+
 var configOnce sync.Once
 var config = make(map[string]interface{})
 var parseConfigErr error
@@ -71,6 +117,161 @@ func parseConfig() {
 		log.Fatal(err.Error())
 		return
 	}
+	if err := verifyConfigSignature(configPath, config); err != nil {
+		log.Fatalf("refusing to use %q: %v", configPath, err)
+	}
+}
+
+// verifyConfigSignature checks, if any trusted signing keys are known,
+// that configPath carries a valid detached JWS signature. A config
+// with no .sig file, no configSigningKey and no trusted_keys file is
+// allowed through unsigned (with a warning) so existing users aren't
+// broken; a .sig file with no trusted keys to check it is an error. Once a
+// trusted key is configured, a missing or invalid signature is fatal.
+// Once a config has ever been successfully verified (see
+// configSignedMarkerPath), that unsigned fallback is refused even if
+// configSigningKey and config.sig later vanish together, since that
+// combination is otherwise indistinguishable from a process that
+// rewrote config to silently drop its own signing key.
+func verifyConfigSignature(configPath string, conf jsonconfig.Obj) error {
+	keys, err := trustedSigningKeys(conf)
+	if err != nil {
+		return err
+	}
+	sigPath := configPath + ".sig"
+	sig, err := ioutil.ReadFile(sigPath)
+	if os.IsNotExist(err) {
+		if len(keys) == 0 {
+			if fileExists(configSignedMarkerPath()) {
+				return fmt.Errorf("%s was previously signed and verified, but neither %s nor a trusted %s are present now; refusing to silently fall back to unsigned", configPath, sigPath, configSigningKey)
+			}
+			log.Printf("client: no %s and no trusted signing keys configured; config signature not checked", sigPath)
+			return nil
+		}
+		return fmt.Errorf("trusted signing keys are configured but %s is missing", sigPath)
+	}
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("%s is present but no trusted signing keys are configured (%q or %s)", sigPath, configSigningKey, TrustedKeysFilePath())
+	}
+	raw, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+	kid, err := configsign.Verify(raw, strings.TrimSpace(string(sig)), keys)
+	if err != nil {
+		return err
+	}
+	log.Printf("client: %s signature verified with trusted key %q", configPath, kid)
+	if err := ioutil.WriteFile(configSignedMarkerPath(), []byte(kid+"\n"), 0600); err != nil {
+		log.Printf("client: failed to record %s: %v", configSignedMarkerPath(), err)
+	}
+	return nil
+}
+
+// trustedSigningKeys collects the public keys trusted to sign the
+// config file: those embedded in the configSigningKey field, plus any
+// in TrustedKeysFilePath.
+func trustedSigningKeys(conf jsonconfig.Obj) (map[string]interface{}, error) {
+	keys := make(map[string]interface{})
+	if v, ok := conf[configSigningKey]; ok {
+		armored, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%q must be a string containing one or more PEM-encoded public keys", configSigningKey)
+		}
+		embedded, err := configsign.ParsePublicKeys([]byte(armored))
+		if err != nil {
+			return nil, err
+		}
+		for kid, key := range embedded {
+			keys[kid] = key
+		}
+	}
+	if data, err := ioutil.ReadFile(TrustedKeysFilePath()); err == nil {
+		fromFile, err := configsign.ParsePublicKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", TrustedKeysFilePath(), err)
+		}
+		for kid, key := range fromFile {
+			keys[kid] = key
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// profiles is a config field holding named bundles of the "server",
+// "auth", "keyId", "secretRing" and "selfPubKeyDir" fields, so a
+// developer can switch between e.g. a local devcam instance and a
+// production server without editing the config or juggling env vars.
+// defaultProfile names the profile to use when -profile and
+// CAMLI_PROFILE are unset.
+const (
+	profilesField       = "profiles"
+	defaultProfileField = "defaultProfile"
+)
+
+// activeProfileName returns the name of the profile to use, in order
+// of precedence: the -profile flag, the CAMLI_PROFILE environment
+// variable, then the config's "defaultProfile" field. It returns "" if
+// none apply, meaning the legacy top-level fields should be used.
+func activeProfileName() string {
+	if flagProfile != nil && *flagProfile != "" {
+		return *flagProfile
+	}
+	if v := os.Getenv("CAMLI_PROFILE"); v != "" {
+		return v
+	}
+	configOnce.Do(parseConfig)
+	name, _ := config[defaultProfileField].(string)
+	return name
+}
+
+// activeProfile returns the selected profile's fields from conf, if a
+// profile is active and conf defines a "profiles" map containing it.
+// It returns an error, rather than exiting the process, if a profile
+// was explicitly requested (by -profile, CAMLI_PROFILE, or
+// defaultProfile) but isn't in conf's "profiles" map: a typo'd profile
+// name shouldn't hard-kill every camtool/camput invocation run in that
+// shell. If conf has no "profiles" map at all, it reports no active
+// profile and no error.
+func activeProfile(conf jsonconfig.Obj) (jsonconfig.Obj, bool, error) {
+	name := activeProfileName()
+	if name == "" {
+		return nil, false, nil
+	}
+	profiles, ok := conf[profilesField].(map[string]interface{})
+	if !ok {
+		return nil, false, nil
+	}
+	profile, ok := profiles[name].(map[string]interface{})
+	if !ok {
+		return nil, false, fmt.Errorf("unknown profile %q in %q", name, ConfigFilePath())
+	}
+	return jsonconfig.Obj(profile), true, nil
+}
+
+// configValue returns conf[key], preferring the value from the active
+// profile (see activeProfileName) when one is active and defines key,
+// and otherwise falling back to the legacy top-level field so configs
+// written before profiles existed keep working unchanged. It returns
+// an error if a profile was explicitly requested but isn't in conf's
+// "profiles" map (see activeProfile).
+func configValue(conf jsonconfig.Obj, key string) (interface{}, bool, error) {
+	profile, active, err := activeProfile(conf)
+	if err != nil {
+		return nil, false, err
+	}
+	if active {
+		if v, ok := profile[key]; ok {
+			return v, true, nil
+		}
+	}
+	v, ok := conf[key]
+	return v, ok, nil
 }
 
 func cleanServer(server string) string {
@@ -90,7 +291,10 @@ func serverOrDie() string {
 		return cleanServer(*flagServer)
 	}
 	configOnce.Do(parseConfig)
-	value, ok := config["server"]
+	value, ok, err := configValue(config, "server")
+	if err != nil {
+		log.Fatal(err)
+	}
 	var server string
 	if ok {
 		server = value.(string)
@@ -122,12 +326,25 @@ func (c *Client) SetupAuth() error {
 	return c.SetupAuthFromConfig(config)
 }
 
+// SetupAuthFromConfig sets up auth from the given configuration.
+// The "auth" value can either be a literal auth string, understood by
+// auth.FromConfig, or a "scheme:ref" secret backend URI (e.g.
+// "keychain:camlistore/default" or "vault:secret/data/camli#password"),
+// which is dereferenced through the secret package first.
 func (c *Client) SetupAuthFromConfig(conf jsonconfig.Obj) error {
-	var err error
-	value, ok := conf["auth"]
+	value, ok, err := configValue(conf, "auth")
+	if err != nil {
+		return err
+	}
 	authString := ""
 	if ok {
 		authString, ok = value.(string)
+		if ok {
+			authString, err = secret.Resolve(authString)
+			if err != nil {
+				return err
+			}
+		}
 		c.authMode, err = auth.FromConfig(authString)
 	} else {
 		c.authMode, err = auth.FromEnv()
@@ -141,17 +358,73 @@ func (c *Client) SignerPublicKeyBlobref() *blobref.BlobRef {
 }
 
 func (c *Client) SecretRingFile() string {
+	return SecretRingFile()
+}
+
+// SecretRingFile returns the GPG secret keyring to use, following the
+// same config/env/default search order as (*Client).SecretRingFile.
+// It's exported standalone so tools like camtool that don't otherwise
+// need a Client (e.g. to re-sign the config file) can find it too.
+func SecretRingFile() string {
 	configOnce.Do(parseConfig)
-	keyRing, ok := config["secretRing"].(string)
-	if ok && keyRing != "" {
-		return keyRing
+	v, ok, err := configValue(config, "secretRing")
+	if err != nil {
+		log.Printf("client: %v; falling back to default secret ring search", err)
+	} else if ok {
+		if keyRing, ok := v.(string); ok && keyRing != "" {
+			return keyRing
+		}
 	}
-	if keyRing = osutil.IdentitySecretRing(); fileExists(keyRing) {
+	if keyRing := osutil.IdentitySecretRing(); fileExists(keyRing) {
 		return keyRing
 	}
 	return jsonsign.DefaultSecRingPath()
 }
 
+// KeyId returns the configured GPG keyId to sign with, or an error if
+// none is configured. It's exported standalone, like SecretRingFile,
+// for tools such as "camtool config sign" that need the configured
+// signing identity without going through SignerPublicKeyBlobref's
+// blobref-based lookup.
+func KeyId() (string, error) {
+	configOnce.Do(parseConfig)
+	value, ok, err := configValue(config, "keyId")
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("no %q in JSON configuration file %q; have you run \"camput init\"?", "keyId", ConfigFilePath())
+	}
+	keyId, ok := value.(string)
+	if !ok || keyId == "" {
+		return "", fmt.Errorf("%q must be a string", "keyId")
+	}
+	return keyId, nil
+}
+
+// KeyPassphrase returns the passphrase protecting the secret ring's
+// signing key, if the config's "keyPassphrase" field (or the active
+// profile's) specifies one. The value can be a literal passphrase or a
+// "scheme:ref" secret backend URI, same as "auth". It returns "" with
+// no error if no passphrase is configured, which callers should take
+// to mean the key is unencrypted or should be prompted for
+// interactively.
+func KeyPassphrase() (string, error) {
+	configOnce.Do(parseConfig)
+	value, ok, err := configValue(config, "keyPassphrase")
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", nil
+	}
+	literal, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%q must be a string", "keyPassphrase")
+	}
+	return secret.Resolve(literal)
+}
+
 func fileExists(name string) bool {
 	_, err := os.Stat(name)
 	return err == nil
@@ -175,13 +448,23 @@ func initSignerPublicKeyBlobref() {
 func getSignerPublicKeyBlobref() *blobref.BlobRef {
 	configOnce.Do(parseConfig)
 	key := "keyId"
-	keyId, ok := config[key].(string)
-	if !ok {
+	keyIdValue, ok, err := configValue(config, key)
+	if err != nil {
+		log.Printf("client: %v", err)
+		return nil
+	}
+	keyId, _ := keyIdValue.(string)
+	if !ok || keyId == "" {
 		log.Printf("No key %q in JSON configuration file %q; have you run \"camput init\"?", key, ConfigFilePath())
 		return nil
 	}
-	keyRing, hasKeyRing := config["secretRing"].(string)
-	if !hasKeyRing {
+	keyRingValue, hasKeyRing, err := configValue(config, "secretRing")
+	if err != nil {
+		log.Printf("client: %v", err)
+		return nil
+	}
+	keyRing, _ := keyRingValue.(string)
+	if !hasKeyRing || keyRing == "" {
 		if fn := osutil.IdentitySecretRing(); fileExists(fn) {
 			keyRing = fn
 		} else if fn := jsonsign.DefaultSecRingPath(); fileExists(fn) {
@@ -196,14 +479,30 @@ func getSignerPublicKeyBlobref() *blobref.BlobRef {
 		log.Printf("Couldn't find keyId %q in secret ring: %v", keyId, err)
 		return nil
 	}
+	if entity.PrivateKey != nil && entity.PrivateKey.Encrypted {
+		passphrase, err := KeyPassphrase()
+		if err != nil {
+			log.Printf("Error getting passphrase for keyId %q: %v", keyId, err)
+			return nil
+		}
+		if err := entity.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+			log.Printf("Error decrypting keyId %q with configured keyPassphrase: %v", keyId, err)
+			return nil
+		}
+	}
 	armored, err := jsonsign.ArmoredPublicKey(entity)
 	if err != nil {
 		log.Printf("Error serializing public key: %v", err)
 		return nil
 	}
 
-	selfPubKeyDir, ok := config["selfPubKeyDir"].(string)
-	if !ok {
+	selfPubKeyDirValue, ok, err := configValue(config, "selfPubKeyDir")
+	if err != nil {
+		log.Printf("client: %v", err)
+		return nil
+	}
+	selfPubKeyDir, _ := selfPubKeyDirValue.(string)
+	if !ok || selfPubKeyDir == "" {
 		log.Printf("No 'selfPubKeyDir' defined in %q", ConfigFilePath())
 		return nil
 	}
@@ -261,7 +560,129 @@ func (c *Client) initTrustedCerts() {
 	}
 }
 
-func (c *Client) GetTrustedCerts() []string {
-	initTrustedCertsOnce.Do(c.initTrustedCerts)
-	return c.trustedCerts
+// tofuEnabled reports whether certificate trust for servers should be
+// learned per-host (TOFU) rather than taken from the static,
+// not-host-scoped trustedCerts list: true when trustedCerts is absent
+// or explicitly set to the sentinel value "tofu".
+func tofuEnabled() bool {
+	configOnce.Do(parseConfig)
+	val, ok := config[trustedCerts]
+	if !ok {
+		return true
+	}
+	s, ok := val.(string)
+	return ok && strings.EqualFold(s, tofuSentinel)
+}
+
+// GetTrustedCerts returns the certificate fingerprints trusted for
+// hostPort. Under the legacy static trustedCerts list it's the same
+// flat list regardless of host; under TOFU it's that host's own
+// learned (and, during an active rotation window, pending) fingerprint
+// only, so a certificate pinned for one host can't authenticate a
+// connection to another.
+func (c *Client) GetTrustedCerts(hostPort string) []string {
+	if e := os.Getenv("CAMLI_TRUSTED_CERT"); e != "" {
+		return []string{strings.ToLower(e)}
+	}
+	if !tofuEnabled() {
+		initTrustedCertsOnce.Do(c.initTrustedCerts)
+		return c.trustedCerts
+	}
+	knownHostsOnce.Do(loadKnownHosts)
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+	hc, ok := knownHosts[strings.ToLower(hostPort)]
+	if !ok {
+		return nil
+	}
+	certs := []string{hc.Fingerprint}
+	if hc.Pending != "" && withinGrace(hc.PendingSince) {
+		certs = append(certs, hc.Pending)
+	}
+	return certs
+}
+
+// CheckServerCert verifies that fingerprint (the lowercase hex SHA-256
+// digest of a server's leaf certificate) is trusted for hostPort.
+//
+// Under the legacy static trustedCerts list, fingerprint must be in
+// that list. Under TOFU, the first-ever contact with hostPort learns
+// and persists its fingerprint; afterwards, fingerprint must match
+// either that pinned value or, during its rotation grace window, a
+// "pending" fingerprint — and a pending fingerprint is only ever
+// present because an operator staged it deliberately (see "camtool
+// cert trust -pending" and "camtool cert rotate"), never because
+// CheckServerCert saw a mismatch and learned it. Any other fingerprint
+// is refused: that's the whole point of pinning past first contact.
+func (c *Client) CheckServerCert(hostPort, fingerprint string) error {
+	fingerprint = strings.ToLower(fingerprint)
+	hostPort = strings.ToLower(hostPort)
+
+	if !tofuEnabled() {
+		for _, want := range c.GetTrustedCerts(hostPort) {
+			if want == fingerprint {
+				return nil
+			}
+		}
+		return fmt.Errorf("certificate for %s (fingerprint %s) is not in the trusted list", hostPort, fingerprint)
+	}
+
+	knownHostsOnce.Do(loadKnownHosts)
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	hc, ok := knownHosts[hostPort]
+	if !ok {
+		hc = &hostCert{Fingerprint: fingerprint}
+		knownHosts[hostPort] = hc
+		log.Printf("TOFU: learned certificate %s for %s", fingerprint, hostPort)
+		return persistKnownHost(hostPort, hc)
+	}
+	switch tofuVerdict(hc, fingerprint) {
+	case tofuMatch:
+		return nil
+	case tofuPendingPromoted:
+		log.Printf("TOFU: %s presented its staged pending certificate %s; rotation confirmed (run \"camtool cert rotate %s\" to make it permanent)", hostPort, fingerprint, hostPort)
+		return nil
+	case tofuPendingExpired:
+		// The grace window lapsed without anyone promoting this
+		// fingerprint. Drop it rather than keep trusting an
+		// un-promoted cert forever, and fall through to refusing
+		// this connection.
+		hc.Pending = ""
+		hc.PendingSince = ""
+		persistKnownHost(hostPort, hc)
+		return fmt.Errorf("certificate for %s (fingerprint %s) was pending but its %d-day rotation window expired without being promoted; run \"camtool cert rotate %s\" or \"camtool cert trust %s %s\" if it's still expected", hostPort, fingerprint, rotationGraceDays, hostPort, hostPort, fingerprint)
+	default:
+		return fmt.Errorf("certificate for %s (fingerprint %s) does not match the pinned certificate %s; stage it first with \"camtool cert trust %s %s -pending\" if this is an expected rotation", hostPort, fingerprint, hc.Fingerprint, hostPort, fingerprint)
+	}
+}
+
+// tofuOutcome is the result of comparing a presented fingerprint
+// against a known host's pinned (and possibly pending) state. It's a
+// pure decision, factored out of CheckServerCert so the TOFU mismatch
+// and grace-expiry rules can be unit tested without a real config file.
+type tofuOutcome int
+
+const (
+	tofuMismatch tofuOutcome = iota
+	tofuMatch
+	tofuPendingPromoted
+	tofuPendingExpired
+)
+
+// tofuVerdict decides the outcome for a known host hc (never nil; the
+// first-contact learn case is handled by CheckServerCert itself)
+// presenting fingerprint.
+func tofuVerdict(hc *hostCert, fingerprint string) tofuOutcome {
+	if fingerprint == hc.Fingerprint {
+		return tofuMatch
+	}
+	if hc.Pending != "" && fingerprint == hc.Pending {
+		if withinGrace(hc.PendingSince) {
+			return tofuPendingPromoted
+		}
+		return tofuPendingExpired
+	}
+	return tofuMismatch
 }
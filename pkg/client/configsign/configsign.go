@@ -0,0 +1,123 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package configsign signs and verifies the client's JSON config file
+// using detached JWS signatures, so a process that can write
+// ~/.camlistore/config can't silently point the client at a different
+// server or a different set of trusted certificates.
+package configsign
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/square/go-jose.v1"
+)
+
+// Canonicalize returns the canonical form of the JSON document in data:
+// object keys sorted and no insignificant whitespace. It works by
+// decoding and re-encoding data, which is sufficient because
+// encoding/json always emits the keys of a map[string]interface{} in
+// sorted order; this lets a signature survive the config file being
+// hand-edited or pretty-printed.
+func Canonicalize(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("configsign: invalid JSON: %v", err)
+	}
+	return json.Marshal(v)
+}
+
+// MutableFields are config fields excluded from the payload that gets
+// signed and verified. client.UpdateConfigFile mutates these in place
+// (e.g. learning a TOFU certificate on a successful handshake) with no
+// human in the loop to re-sign the file afterwards, so a config.sig
+// covering them would go stale the moment the client did its job,
+// fataling out every later invocation in verifyConfigSignature. A field
+// belongs here only if an unattended process is expected to rewrite it;
+// anything else (server, auth, keyId, trustedCerts, profiles, ...)
+// still invalidates the signature if changed, which is the point.
+var MutableFields = []string{"knownHosts"}
+
+// CanonicalizeSignable is like Canonicalize, but first removes
+// MutableFields from data's top-level object, so that signing and
+// verification are insensitive to changes in those fields alone. data
+// must be a JSON object.
+func CanonicalizeSignable(data []byte) ([]byte, error) {
+	var v map[string]interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("configsign: invalid JSON: %v", err)
+	}
+	for _, k := range MutableFields {
+		delete(v, k)
+	}
+	return json.Marshal(v)
+}
+
+// Sign signs the canonical signable form of data (see
+// CanonicalizeSignable) with signer and returns the compact
+// serialization of the resulting JWS, suitable for writing to a ".sig"
+// file next to the signed config.
+func Sign(data []byte, signer jose.Signer) (string, error) {
+	payload, err := CanonicalizeSignable(data)
+	if err != nil {
+		return "", err
+	}
+	obj, err := signer.Sign(payload)
+	if err != nil {
+		return "", fmt.Errorf("configsign: signing: %v", err)
+	}
+	return obj.CompactSerialize()
+}
+
+// Verify checks that sig is a valid compact JWS over the canonical
+// signable form of data (see CanonicalizeSignable), signed by one of
+// keys (keyed by kid). It returns the kid of whichever key verified the
+// signature, or an error if none did.
+func Verify(data []byte, sig string, keys map[string]interface{}) (kid string, err error) {
+	if len(keys) == 0 {
+		return "", fmt.Errorf("configsign: no trusted keys provided")
+	}
+	payload, err := CanonicalizeSignable(data)
+	if err != nil {
+		return "", err
+	}
+	obj, err := jose.ParseSigned(sig)
+	if err != nil {
+		return "", fmt.Errorf("configsign: parsing signature: %v", err)
+	}
+	want := string(payload)
+
+	// Try the kid named in the JWS header first, so key rotation doesn't
+	// require trying every trusted key on every load.
+	if len(obj.Signatures) > 0 {
+		if id := obj.Signatures[0].Header.KeyID; id != "" {
+			if key, ok := keys[id]; ok {
+				if got, err := obj.Verify(key); err == nil && string(got) == want {
+					return id, nil
+				}
+			}
+		}
+	}
+	for id, key := range keys {
+		got, err := obj.Verify(key)
+		if err != nil || string(got) != want {
+			continue
+		}
+		return id, nil
+	}
+	return "", fmt.Errorf("configsign: signature does not verify against any trusted key")
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configsign
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"testing"
+
+	"gopkg.in/square/go-jose.v1"
+)
+
+// newTestSigner generates a throwaway RSA key and returns a signer
+// using it along with its public key, for tests that don't care about
+// test identity beyond "some valid key".
+func newTestSigner(t *testing.T) (jose.Signer, *rsa.PublicKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating test key: %v", err)
+	}
+	signer, err := jose.NewSigner(jose.RS256, key)
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	return signer, &key.PublicKey
+}
+
+// newTestSignerWithKid is like newTestSigner, but also returns the kid
+// (as derived by ParsePublicKeys/Fingerprint from the key's DER
+// encoding) and stamps it into the signer's JWS header, the way
+// "camtool config sign" does.
+func newTestSignerWithKid(t *testing.T) (signer jose.Signer, kid string, pub *rsa.PublicKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating test key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("marshaling test public key: %v", err)
+	}
+	kid = Fingerprint(der)
+	signer, err = jose.NewSigner(jose.RS256, &jose.JsonWebKey{Key: key, KeyID: kid})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	return signer, kid, &key.PublicKey
+}
+
+// TestVerifyKidFastPath checks that a signature produced with a kid
+// stamped in its JWS header (as "camtool config sign" now does) is
+// matched against the corresponding trusted key by kid, even when
+// other, unrelated trusted keys are also configured.
+func TestVerifyKidFastPath(t *testing.T) {
+	data := []byte(`{"server":"https://example.com"}`)
+
+	signer, kid, pub := newTestSignerWithKid(t)
+	sig, err := Sign(data, signer)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	_, otherPub := newTestSigner(t)
+	keys := map[string]interface{}{
+		"some-other-key": otherPub,
+		kid:              pub,
+	}
+	gotKid, err := Verify(data, sig, keys)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if gotKid != kid {
+		t.Fatalf("Verify returned kid %q, want %q", gotKid, kid)
+	}
+}
+
+// TestCanonicalizeSignableExcludesMutableFields verifies that adding
+// (or changing) a MutableFields entry like "knownHosts" doesn't change
+// the signable payload, so a running client persisting a TOFU-learned
+// certificate doesn't invalidate an existing config.sig.
+func TestCanonicalizeSignableExcludesMutableFields(t *testing.T) {
+	before := `{"server":"https://example.com","knownHosts":{}}`
+	after := `{"server":"https://example.com","knownHosts":{"example.com:443":{"fingerprint":"abc123"}}}`
+
+	beforeSignable, err := CanonicalizeSignable([]byte(before))
+	if err != nil {
+		t.Fatalf("CanonicalizeSignable(before): %v", err)
+	}
+	afterSignable, err := CanonicalizeSignable([]byte(after))
+	if err != nil {
+		t.Fatalf("CanonicalizeSignable(after): %v", err)
+	}
+	if string(beforeSignable) != string(afterSignable) {
+		t.Fatalf("signable payload changed when only knownHosts changed:\nbefore: %s\nafter:  %s", beforeSignable, afterSignable)
+	}
+
+	// Sanity check: a change to a field that isn't in MutableFields
+	// must still change the signable payload.
+	changedServer := `{"server":"https://evil.example.com","knownHosts":{}}`
+	changedSignable, err := CanonicalizeSignable([]byte(changedServer))
+	if err != nil {
+		t.Fatalf("CanonicalizeSignable(changedServer): %v", err)
+	}
+	if string(changedSignable) == string(beforeSignable) {
+		t.Fatalf("signable payload didn't change when \"server\" changed")
+	}
+}
+
+// TestSignVerifyTOFUReload simulates the sequence that used to brick a
+// signed config: sign it, "learn" a TOFU host (as persistKnownHost
+// would, by adding to knownHosts without re-signing), and confirm the
+// original signature still verifies against the mutated config.
+func TestSignVerifyTOFUReload(t *testing.T) {
+	signer, pub := newTestSigner(t)
+
+	original := []byte(`{"server":"https://example.com","knownHosts":{}}`)
+	sig, err := Sign(original, signer)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	keys := map[string]interface{}{"test-key": pub}
+	if _, err := Verify(original, sig, keys); err != nil {
+		t.Fatalf("Verify(original): %v", err)
+	}
+
+	learned := []byte(`{"server":"https://example.com","knownHosts":{"example.com:443":{"fingerprint":"abc123"}}}`)
+	if _, err := Verify(learned, sig, keys); err != nil {
+		t.Fatalf("Verify(learned) should still succeed after a TOFU learn: %v", err)
+	}
+
+	tampered := []byte(`{"server":"https://evil.example.com","knownHosts":{}}`)
+	if _, err := Verify(tampered, sig, keys); err == nil {
+		t.Fatalf("Verify(tampered) should fail when a non-mutable field changes")
+	}
+}
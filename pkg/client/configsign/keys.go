@@ -0,0 +1,63 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configsign
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+)
+
+// ParsePublicKeys parses zero or more PEM-encoded public keys from data
+// (as found in the configSigningKey config field or the trusted_keys
+// file) and returns them keyed by kid. The kid of a key is the first 16
+// hex characters of the SHA-256 digest of its DER encoding, unless the
+// PEM block carries a "Kid" header, in which case that value is used
+// instead so a key can be given a memorable name for rotation.
+func ParsePublicKeys(data []byte) (map[string]interface{}, error) {
+	keys := make(map[string]interface{})
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("configsign: parsing public key: %v", err)
+		}
+		kid := block.Headers["Kid"]
+		if kid == "" {
+			kid = Fingerprint(block.Bytes)
+		}
+		keys[kid] = pub
+	}
+	if len(keys) == 0 && len(data) > 0 {
+		return nil, fmt.Errorf("configsign: no PEM-encoded public keys found")
+	}
+	return keys, nil
+}
+
+// Fingerprint returns the short kid derived from the SHA-256 digest of
+// a DER-encoded public key.
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])[:16]
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"camlistore.org/pkg/client/configsign"
+)
+
+// UpdateConfigFile reads the config file, applies mutate to its
+// parsed form, and writes the result back atomically: it writes to a
+// temp file in the same directory and renames it over the original, so
+// a crash or concurrent reader never observes a partial file. A sibling
+// lock file serializes concurrent writers (e.g. a running client
+// learning a TOFU cert at the same time as a "camtool" invocation).
+//
+// This only touches the on-disk file, never the in-memory config
+// singleton (see config.go's configOnce/config): re-running parseConfig
+// here would re-verify the config signature against what's now a stale
+// .sig, fataling out a long-running client the moment it persists a
+// learned cert, and reassigning the shared config map while other
+// goroutines read it would race. Callers that keep their own in-memory
+// mirror of what they just wrote (as CheckServerCert does for
+// knownHosts, under knownHostsMu) are responsible for keeping it in
+// sync themselves.
+//
+// Rewriting knownHosts specifically (the only field a running client,
+// as opposed to an operator running camtool, ever mutates here) also
+// doesn't go stale against an existing config.sig: knownHosts is in
+// configsign.MutableFields, so it's excluded from the signed payload.
+// Anything else mutate touches still requires a "camtool config sign".
+func UpdateConfigFile(mutate func(conf map[string]interface{}) error) error {
+	return updateConfigFile(mutate)
+}
+
+func updateConfigFile(mutate func(conf map[string]interface{}) error) error {
+	unlock, err := lockConfigFile()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	configPath := ConfigFilePath()
+	data, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", configPath, err)
+	}
+	conf := make(map[string]interface{})
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return fmt.Errorf("parsing %s: %v", configPath, err)
+	}
+	if err := mutate(conf); err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(configPath), "config.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if _, err := tmp.Write(out); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, configPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	before, errBefore := configsign.CanonicalizeSignable(data)
+	after, errAfter := configsign.CanonicalizeSignable(out)
+	signedChanged := errBefore != nil || errAfter != nil || string(before) != string(after)
+	if signedChanged && fileExists(ConfigSignatureFilePath()) {
+		log.Printf("client: %s is now stale after updating %s; run \"camtool config sign\" to re-sign it", ConfigSignatureFilePath(), configPath)
+	}
+	return nil
+}
+
+// lockConfigFile acquires a simple advisory lock on the config file by
+// exclusively creating a sibling ".lock" file, retrying briefly if
+// another process already holds it. It returns a func that releases
+// the lock.
+func lockConfigFile() (unlock func(), err error) {
+	lockPath := ConfigFilePath() + ".lock"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock on %s", lockPath)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
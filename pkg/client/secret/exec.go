@@ -0,0 +1,48 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secret
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func init() {
+	Register("exec", execProvider{})
+}
+
+// execProvider runs a user-specified helper program and reads the
+// secret from its first line of stdout, the same convention git's
+// credential helpers use. A config value of "exec:pass show camli/auth"
+// runs that command through the shell.
+type execProvider struct{}
+
+func (execProvider) Get(ref string) (string, error) {
+	if strings.TrimSpace(ref) == "" {
+		return "", fmt.Errorf("empty exec: command")
+	}
+	cmd := exec.Command("/bin/sh", "-c", ref)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("running %q: %v", ref, err)
+	}
+	line := strings.SplitN(out.String(), "\n", 2)[0]
+	return strings.TrimRight(line, "\r"), nil
+}
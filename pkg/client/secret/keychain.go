@@ -0,0 +1,49 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secret
+
+import (
+	"fmt"
+	"strings"
+)
+
+func init() {
+	Register("keychain", keychainProvider{})
+}
+
+// keychainProvider reads a secret from the OS-native credential store:
+// Keychain on macOS, the Secret Service (libsecret) on Linux, and
+// Credential Manager on Windows. ref is an account name such as
+// "camlistore/default"; it's split into a service and account at the
+// last slash, so "camlistore/default" means service "camlistore",
+// account "default".
+type keychainProvider struct{}
+
+func (keychainProvider) Get(ref string) (string, error) {
+	service, account, ok := cutLast(ref, "/")
+	if !ok {
+		service, account = "camlistore", ref
+	}
+	if account == "" {
+		return "", fmt.Errorf("keychain ref %q is missing an account name", ref)
+	}
+	return keychainLookup(service, account)
+}
+
+func trimOneNewline(s string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(s, "\n"), "\r")
+}
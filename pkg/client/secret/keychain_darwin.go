@@ -0,0 +1,40 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secret
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+)
+
+const keychainOS = "darwin"
+
+// keychainLookup shells out to /usr/bin/security, the same way git
+// and countless other macOS tools read the login keychain without
+// requiring a cgo dependency.
+func keychainLookup(service, account string) (string, error) {
+	cmd := exec.Command("/usr/bin/security", "find-generic-password",
+		"-s", service, "-a", account, "-w")
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("security find-generic-password: %v: %s", err, stderr.String())
+	}
+	return trimOneNewline(out.String()), nil
+}
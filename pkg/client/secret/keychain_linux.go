@@ -0,0 +1,39 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secret
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+)
+
+const keychainOS = "linux"
+
+// keychainLookup shells out to secret-tool, the CLI shipped with
+// libsecret, so we talk to the Secret Service over D-Bus without
+// linking against it directly.
+func keychainLookup(service, account string) (string, error) {
+	cmd := exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("secret-tool lookup: %v: %s", err, stderr.String())
+	}
+	return trimOneNewline(out.String()), nil
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secret
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+)
+
+const keychainOS = "windows"
+
+// keychainLookup uses PowerShell's CredentialManager module
+// (Get-StoredCredential) to read from Windows Credential Manager. Most machines don't
+// have the CredentialManager module installed by default; in that
+// case this returns an error naming the missing dependency rather
+// than failing silently.
+func keychainLookup(service, account string) (string, error) {
+	target := service + ":" + account
+	script := fmt.Sprintf(
+		`(Get-StoredCredential -Target %q).GetNetworkCredential().Password`,
+		target)
+	cmd := exec.Command("powershell", "-NoProfile", "-Command", script)
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("reading Credential Manager entry %q (requires the CredentialManager PowerShell module): %v: %s", target, err, stderr.String())
+	}
+	return trimOneNewline(out.String()), nil
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package secret resolves credentials (the "auth" config field, and the
+// GPG secret ring passphrase) out of a pluggable backend instead of
+// storing them as plaintext in ~/.camlistore/config. A config value
+// that looks like a "scheme:rest" URI is dereferenced through the
+// matching Provider; anything else is returned unchanged, so existing
+// plaintext configs keep working.
+package secret
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Provider fetches a single secret value (a password, token, or
+// passphrase) from some backend.
+type Provider interface {
+	// Get returns the secret named by ref, the part of the config
+	// URI after the "scheme:" prefix.
+	Get(ref string) (string, error)
+}
+
+// providers maps a URI scheme (e.g. "vault") to the Provider that
+// handles it. Backend packages register themselves in an init func.
+var providers = make(map[string]Provider)
+
+// Register adds p as the Provider for URIs of the form scheme:ref. It
+// panics if scheme is already registered, since that's a programming
+// error, not a runtime condition.
+func Register(scheme string, p Provider) {
+	if _, dup := providers[scheme]; dup {
+		panic("secret: Register called twice for scheme " + scheme)
+	}
+	providers[scheme] = p
+}
+
+// Resolve dereferences value if it names a secret backend ("scheme:ref",
+// e.g. "keychain:camlistore/default" or "vault:secret/data/camli#password"),
+// and otherwise returns it unchanged so a plain literal in the config
+// (or in an environment variable) still works.
+func Resolve(value string) (string, error) {
+	scheme, ref, ok := splitURI(value)
+	if !ok {
+		return value, nil
+	}
+	p, ok := providers[scheme]
+	if !ok {
+		return "", fmt.Errorf("secret: no provider registered for scheme %q", scheme)
+	}
+	got, err := p.Get(ref)
+	if err != nil {
+		return "", fmt.Errorf("secret: resolving %q: %v", value, err)
+	}
+	return got, nil
+}
+
+func splitURI(value string) (scheme, ref string, ok bool) {
+	i := strings.Index(value, ":")
+	if i <= 0 {
+		return "", "", false
+	}
+	scheme, ref = value[:i], value[i+1:]
+	if _, registered := providers[scheme]; !registered {
+		return "", "", false
+	}
+	return scheme, ref, true
+}
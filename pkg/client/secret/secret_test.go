@@ -0,0 +1,123 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secret
+
+import (
+	"fmt"
+	"testing"
+)
+
+// fakeProvider is a Provider whose Get is driven entirely by the test.
+type fakeProvider struct {
+	get func(ref string) (string, error)
+}
+
+func (p fakeProvider) Get(ref string) (string, error) { return p.get(ref) }
+
+// withRegistered registers p under scheme for the duration of fn,
+// manipulating the package-level providers map directly since Register
+// panics on a duplicate and there's no Unregister.
+func withRegistered(t *testing.T, scheme string, p Provider, fn func()) {
+	t.Helper()
+	if _, dup := providers[scheme]; dup {
+		t.Fatalf("scheme %q already registered", scheme)
+	}
+	providers[scheme] = p
+	defer delete(providers, scheme)
+	fn()
+}
+
+func TestResolveLiteralUnchanged(t *testing.T) {
+	for _, v := range []string{"hunter2", "", "userpass:alice:hunter2", "not a uri at all"} {
+		got, err := Resolve(v)
+		if err != nil {
+			t.Errorf("Resolve(%q): unexpected error: %v", v, err)
+			continue
+		}
+		if got != v {
+			t.Errorf("Resolve(%q) = %q, want unchanged", v, got)
+		}
+	}
+}
+
+func TestResolveUnregisteredSchemeLeftUntouched(t *testing.T) {
+	// "userpass:" looks like a scheme:ref URI, but nothing registers
+	// for it, so Resolve must return it as a literal rather than
+	// erroring: only schemes with a registered Provider are ever
+	// dereferenced.
+	const v = "userpass:alice:hunter2"
+	got, err := Resolve(v)
+	if err != nil {
+		t.Fatalf("Resolve(%q): %v", v, err)
+	}
+	if got != v {
+		t.Fatalf("Resolve(%q) = %q, want unchanged", v, got)
+	}
+}
+
+func TestResolveRegisteredScheme(t *testing.T) {
+	withRegistered(t, "testscheme", fakeProvider{
+		get: func(ref string) (string, error) {
+			return "secret-for-" + ref, nil
+		},
+	}, func() {
+		got, err := Resolve("testscheme:myref")
+		if err != nil {
+			t.Fatalf("Resolve: %v", err)
+		}
+		if want := "secret-for-myref"; got != want {
+			t.Fatalf("Resolve = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestResolveProviderError(t *testing.T) {
+	withRegistered(t, "testscheme", fakeProvider{
+		get: func(ref string) (string, error) {
+			return "", fmt.Errorf("boom")
+		},
+	}, func() {
+		if _, err := Resolve("testscheme:myref"); err == nil {
+			t.Fatalf("Resolve: expected error from provider to propagate")
+		}
+	})
+}
+
+func TestSplitURI(t *testing.T) {
+	withRegistered(t, "testscheme", fakeProvider{get: func(string) (string, error) { return "", nil }}, func() {
+		cases := []struct {
+			in         string
+			wantScheme string
+			wantRef    string
+			wantOK     bool
+		}{
+			{"testscheme:myref", "testscheme", "myref", true},
+			{"testscheme:", "testscheme", "", true},
+			{"unregistered:myref", "", "", false},
+			{"no-colon-at-all", "", "", false},
+			{":leading-colon", "", "", false},
+			{"", "", "", false},
+		}
+		for _, c := range cases {
+			scheme, ref, ok := splitURI(c.in)
+			if ok != c.wantOK || scheme != c.wantScheme || ref != c.wantRef {
+				t.Errorf("splitURI(%q) = (%q, %q, %v), want (%q, %q, %v)",
+					c.in, scheme, ref, ok, c.wantScheme, c.wantRef, c.wantOK)
+			}
+		}
+	})
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secret
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+func init() {
+	Register("vault", vaultProvider{})
+}
+
+// vaultProvider reads a secret out of a HashiCorp Vault KV v2 mount.
+// ref has the form "path/to/secret#field", e.g.
+// "secret/data/camli#password". The Vault address comes from
+// VAULT_ADDR; authentication is via VAULT_TOKEN if set, or else
+// AppRole login using VAULT_ROLE_ID and VAULT_SECRET_ID.
+type vaultProvider struct{}
+
+func (vaultProvider) Get(ref string) (string, error) {
+	path, field, ok := cutLast(ref, "#")
+	if !ok || path == "" || field == "" {
+		return "", fmt.Errorf("vault ref %q must be of the form path#field", ref)
+	}
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+	token, err := vaultToken(addr)
+	if err != nil {
+		return "", err
+	}
+	var body struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := vaultRequest("GET", addr+"/v1/"+path, token, nil, &body); err != nil {
+		return "", err
+	}
+	v, ok := body.Data.Data[field]
+	if !ok {
+		return "", fmt.Errorf("field %q not found at %q", field, path)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("field %q at %q is not a string", field, path)
+	}
+	return s, nil
+}
+
+func vaultToken(addr string) (string, error) {
+	if t := os.Getenv("VAULT_TOKEN"); t != "" {
+		return t, nil
+	}
+	roleID, secretID := os.Getenv("VAULT_ROLE_ID"), os.Getenv("VAULT_SECRET_ID")
+	if roleID == "" || secretID == "" {
+		return "", fmt.Errorf("no VAULT_TOKEN, and no VAULT_ROLE_ID/VAULT_SECRET_ID for AppRole login")
+	}
+	reqBody := strings.NewReader(fmt.Sprintf(`{"role_id":%q,"secret_id":%q}`, roleID, secretID))
+	var resp struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := vaultRequest("POST", addr+"/v1/auth/approle/login", "", reqBody, &resp); err != nil {
+		return "", fmt.Errorf("AppRole login: %v", err)
+	}
+	if resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("AppRole login did not return a client token")
+	}
+	return resp.Auth.ClientToken, nil
+}
+
+func vaultRequest(method, url, token string, body io.Reader, into interface{}) error {
+	if body == nil {
+		body = strings.NewReader("")
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(into)
+}
+
+// cutLast splits s on the last occurrence of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// tofuSentinel is the trustedCerts value that requests trust-on-first-use
+// pinning instead of a static fingerprint list.
+const tofuSentinel = "tofu"
+
+// knownHostsField is the config field holding the per-host TOFU state.
+const knownHostsField = "knownHosts"
+
+// rotationGraceDays is how long, after a pending fingerprint is staged
+// for a host, both the pinned and the pending fingerprint are accepted.
+const rotationGraceDays = 30
+
+// hostCert is a host's pinned certificate state: its current trusted
+// fingerprint, and optionally one staged by an operator as pending
+// acceptance during a rotation window.
+type hostCert struct {
+	Fingerprint  string // lowercase hex SHA-256 of the leaf cert, currently trusted
+	Pending      string // lowercase hex SHA-256 of a staged cert, not yet promoted
+	PendingSince string // RFC3339 timestamp at which Pending was staged
+}
+
+var (
+	knownHostsOnce sync.Once
+	knownHostsMu   sync.Mutex
+	knownHosts     = make(map[string]*hostCert) // keyed by lowercase "host:port"
+)
+
+// loadKnownHosts populates knownHosts from the config file's
+// "knownHosts" field.
+func loadKnownHosts() {
+	configOnce.Do(parseConfig)
+	val, ok := config[knownHostsField].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for host, v := range val {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		hc := &hostCert{}
+		hc.Fingerprint, _ = obj["fingerprint"].(string)
+		hc.Pending, _ = obj["pending"].(string)
+		hc.PendingSince, _ = obj["pendingSince"].(string)
+		knownHosts[strings.ToLower(host)] = hc
+	}
+}
+
+// withinGrace reports whether pendingSince is within rotationGraceDays
+// of now.
+func withinGrace(pendingSince string) bool {
+	t, err := time.Parse(time.RFC3339, pendingSince)
+	if err != nil {
+		return false
+	}
+	return time.Since(t) < rotationGraceDays*24*time.Hour
+}
+
+// persistKnownHost writes hc as hostPort's entry in the config file's
+// knownHosts map. Call with knownHostsMu held, since it reflects
+// in-memory state that's only consistent under that lock.
+func persistKnownHost(hostPort string, hc *hostCert) error {
+	return updateConfigFile(func(conf map[string]interface{}) error {
+		hosts, ok := conf[knownHostsField].(map[string]interface{})
+		if !ok {
+			hosts = make(map[string]interface{})
+			conf[knownHostsField] = hosts
+		}
+		entry := map[string]interface{}{"fingerprint": hc.Fingerprint}
+		if hc.Pending != "" {
+			entry["pending"] = hc.Pending
+			entry["pendingSince"] = hc.PendingSince
+		}
+		hosts[hostPort] = entry
+		return nil
+	})
+}
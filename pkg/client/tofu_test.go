@@ -0,0 +1,102 @@
+/*
+Copyright 2014 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWithinGrace(t *testing.T) {
+	cases := []struct {
+		name string
+		ago  time.Duration
+		want bool
+	}{
+		{"just now", 0, true},
+		{"a day ago", 24 * time.Hour, true},
+		{"just under the window", rotationGraceDays*24*time.Hour - time.Hour, true},
+		{"just over the window", rotationGraceDays*24*time.Hour + time.Hour, false},
+		{"well past the window", 365 * 24 * time.Hour, false},
+	}
+	for _, c := range cases {
+		since := time.Now().Add(-c.ago).Format(time.RFC3339)
+		if got := withinGrace(since); got != c.want {
+			t.Errorf("%s: withinGrace(%s) = %v, want %v", c.name, since, got, c.want)
+		}
+	}
+}
+
+func TestWithinGraceInvalidTimestamp(t *testing.T) {
+	for _, s := range []string{"", "not-a-timestamp", "2020-01-01"} {
+		if withinGrace(s) {
+			t.Errorf("withinGrace(%q) = true, want false for an unparseable timestamp", s)
+		}
+	}
+}
+
+// TestTofuVerdict exercises the TOFU trust decision for a known host
+// directly, covering the two bugs the maintainer flagged: an unstaged
+// mismatch must never be silently accepted as pending, and an expired
+// pending fingerprint must be dropped rather than renewed.
+func TestTofuVerdict(t *testing.T) {
+	recentlyStaged := time.Now().Add(-1 * time.Hour).Format(time.RFC3339)
+	expiredStaged := time.Now().Add(-(rotationGraceDays + 1) * 24 * time.Hour).Format(time.RFC3339)
+
+	cases := []struct {
+		name        string
+		hc          *hostCert
+		fingerprint string
+		want        tofuOutcome
+	}{
+		{
+			name:        "matches pinned fingerprint",
+			hc:          &hostCert{Fingerprint: "aaaa"},
+			fingerprint: "aaaa",
+			want:        tofuMatch,
+		},
+		{
+			name:        "unstaged mismatch is rejected, not auto-learned as pending",
+			hc:          &hostCert{Fingerprint: "aaaa"},
+			fingerprint: "bbbb",
+			want:        tofuMismatch,
+		},
+		{
+			name:        "staged pending within grace window is promoted",
+			hc:          &hostCert{Fingerprint: "aaaa", Pending: "bbbb", PendingSince: recentlyStaged},
+			fingerprint: "bbbb",
+			want:        tofuPendingPromoted,
+		},
+		{
+			name:        "staged pending past grace window expires, not re-armed",
+			hc:          &hostCert{Fingerprint: "aaaa", Pending: "bbbb", PendingSince: expiredStaged},
+			fingerprint: "bbbb",
+			want:        tofuPendingExpired,
+		},
+		{
+			name:        "a third, unrelated fingerprint is rejected even with a pending entry",
+			hc:          &hostCert{Fingerprint: "aaaa", Pending: "bbbb", PendingSince: recentlyStaged},
+			fingerprint: "cccc",
+			want:        tofuMismatch,
+		},
+	}
+	for _, c := range cases {
+		if got := tofuVerdict(c.hc, c.fingerprint); got != c.want {
+			t.Errorf("%s: tofuVerdict(%+v, %q) = %v, want %v", c.name, c.hc, c.fingerprint, got, c.want)
+		}
+	}
+}